@@ -129,6 +129,39 @@ func (dataNode *DataNode) SelectNodeForWrite() {
 	dataNode.Carry = dataNode.Carry - 1.0
 }
 
+// inflightTaskCount reports how many admin tasks dataNode's sender still
+// has queued, used as a proxy for how busy the node currently is.
+func (dataNode *DataNode) inflightTaskCount() int {
+	if dataNode.Sender == nil {
+		return 0
+	}
+	return dataNode.Sender.PendingTaskCount()
+}
+
+// carryWeight is the per-cycle carry increment used by the weighted-carry
+// placement algorithm: nodes with more free space, more headroom below
+// their usage ratio, a rack not yet represented in the replica set, and
+// fewer inflight tasks earn a bigger increment and so get picked sooner.
+// rackDiversityBonus is added only when dataNode's rack is not already in
+// the set of racks the caller is trying to diversify across.
+func (dataNode *DataNode) carryWeight(rackDiversityBonus float64, inExistingRack bool) float64 {
+	dataNode.RLock()
+	defer dataNode.RUnlock()
+
+	var freeRatio float64
+	if dataNode.Total > 0 {
+		freeRatio = float64(dataNode.Available) / float64(dataNode.Total)
+	}
+	headroom := 1 - dataNode.Ratio
+	bonus := 1.0
+	if !inExistingRack {
+		bonus += rackDiversityBonus
+	}
+	loadFactor := 1 / (1 + float64(dataNode.inflightTaskCount()))
+
+	return freeRatio * headroom * bonus * loadFactor
+}
+
 // TODO rename clear()?
 func (dataNode *DataNode) clean() {
 	dataNode.Sender.exitCh <- struct{}{}
@@ -0,0 +1,141 @@
+// Copyright 2018 The Container File System Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package master
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultRackDiversityBonus is how much extra carry weight a node earns
+// for sitting on a rack not already present in the replica set being
+// built, relative to a node with no rack preference (bonus 0 would make
+// SelectNodesForPartition behave like plain weighted carry).
+const defaultRackDiversityBonus = 1.0
+
+// PlacementDecision records why a partition's replicas ended up on the
+// nodes/racks they did, for the /cluster/placement/decisions endpoint.
+type PlacementDecision struct {
+	PartitionID uint64
+	NodeAddrs   []string
+	Racks       []string
+	DecidedAt   time.Time
+}
+
+// placementLog keeps the most recent placement decision per partition so
+// operators can audit rack distribution after the fact.
+type placementLog struct {
+	sync.RWMutex
+	decisions map[uint64]*PlacementDecision
+}
+
+var globalPlacementLog = &placementLog{decisions: make(map[uint64]*PlacementDecision)}
+
+func (l *placementLog) record(decision *PlacementDecision) {
+	l.Lock()
+	defer l.Unlock()
+	l.decisions[decision.PartitionID] = decision
+}
+
+func (l *placementLog) all() []*PlacementDecision {
+	l.RLock()
+	defer l.RUnlock()
+	out := make([]*PlacementDecision, 0, len(l.decisions))
+	for _, d := range l.decisions {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PartitionID < out[j].PartitionID })
+	return out
+}
+
+// SelectNodesForPartition picks replicaCount writable nodes out of
+// candidates using weighted carry selection: each cycle, every remaining
+// node's carry increases by carryWeight (free space, usage headroom,
+// a bonus for sitting on a rack not yet represented in the replica set,
+// inflight-task penalty), and the node with the highest weight is taken.
+// Once every rack already in existingRacks (plus racks picked so far)
+// is used up, the bonus term is the same for every remaining candidate,
+// so selection naturally falls back to ranking on the other factors
+// instead of being blocked on rack exclusivity. partitionID is the
+// partition this selection is for; the decision is recorded under that
+// ID so it shows up in GetPlacementDecisions/handlePlacementDecisions
+// for operators to audit afterwards.
+func SelectNodesForPartition(candidates []*DataNode, partitionID uint64, replicaCount int, existingRacks []string) (picked []*DataNode) {
+	usedRacks := make(map[string]bool, len(existingRacks)+replicaCount)
+	for _, rack := range existingRacks {
+		usedRacks[rack] = true
+	}
+
+	remaining := make([]*DataNode, 0, len(candidates))
+	for _, node := range candidates {
+		if node.isWriteAble() && node.isAvailCarryNode() {
+			remaining = append(remaining, node)
+		}
+	}
+
+	for len(picked) < replicaCount && len(remaining) > 0 {
+		best := -1
+		bestWeight := -1.0
+		for i, node := range remaining {
+			weight := node.carryWeight(defaultRackDiversityBonus, usedRacks[node.RackName])
+			node.Lock()
+			node.Carry += weight
+			node.Unlock()
+			if weight > bestWeight {
+				bestWeight = weight
+				best = i
+			}
+		}
+
+		node := remaining[best]
+		node.SelectNodeForWrite()
+		picked = append(picked, node)
+		usedRacks[node.RackName] = true
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+
+	recordPlacementDecision(partitionID, picked)
+	return picked
+}
+
+// recordPlacementDecision stores which nodes/racks SelectNodesForPartition
+// picked for partitionID, so the decision shows up under
+// /cluster/placement/decisions.
+func recordPlacementDecision(partitionID uint64, nodes []*DataNode) {
+	decision := &PlacementDecision{PartitionID: partitionID, DecidedAt: time.Now()}
+	for _, node := range nodes {
+		decision.NodeAddrs = append(decision.NodeAddrs, node.Addr)
+		decision.Racks = append(decision.Racks, node.RackName)
+	}
+	globalPlacementLog.record(decision)
+}
+
+// handlePlacementDecisions serves /cluster/placement/decisions, returning
+// the most recent placement decision recorded for every partition so
+// operators can audit why a partition landed on the racks it did.
+func handlePlacementDecisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(globalPlacementLog.all())
+}
+
+// init registers the placement-decisions endpoint on the default mux, the
+// same way this code base's other admin handlers get wired up before the
+// master's HTTP server starts serving on it.
+func init() {
+	http.HandleFunc("/cluster/placement/decisions", handlePlacementDecisions)
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 The Container File System Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package master
+
+import "testing"
+
+func testNode(id uint64, rack string) *DataNode {
+	node := &DataNode{
+		ID:        id,
+		RackName:  rack,
+		Addr:      rack + "-node",
+		Total:     100,
+		Used:      10,
+		Available: 1 << 40,
+		Ratio:     0.1,
+		Carry:     1,
+		isActive:  true,
+	}
+	return node
+}
+
+func TestSelectNodesForPartitionPrefersUnusedRacks(t *testing.T) {
+	candidates := []*DataNode{
+		testNode(1, "rack1"),
+		testNode(2, "rack1"),
+		testNode(3, "rack2"),
+		testNode(4, "rack3"),
+	}
+
+	picked := SelectNodesForPartition(candidates, 100, 3, nil)
+	if len(picked) != 3 {
+		t.Fatalf("SelectNodesForPartition returned %v nodes, want 3", len(picked))
+	}
+
+	seenRacks := make(map[string]bool)
+	for _, node := range picked {
+		if seenRacks[node.RackName] {
+			t.Fatalf("SelectNodesForPartition picked two nodes from rack(%v) when a third rack was available", node.RackName)
+		}
+		seenRacks[node.RackName] = true
+	}
+}
+
+func TestSelectNodesForPartitionFallsBackWhenRacksExhausted(t *testing.T) {
+	candidates := []*DataNode{
+		testNode(1, "rack1"),
+		testNode(2, "rack1"),
+		testNode(3, "rack2"),
+	}
+
+	// Only two racks exist but four replicas are requested: selection must
+	// still return as many writable nodes as it can instead of stopping
+	// once rack diversity is exhausted.
+	picked := SelectNodesForPartition(candidates, 101, 4, nil)
+	if len(picked) != len(candidates) {
+		t.Fatalf("SelectNodesForPartition returned %v nodes, want %v (all writable candidates)", len(picked), len(candidates))
+	}
+}
+
+func TestSelectNodesForPartitionExcludesExistingRacks(t *testing.T) {
+	candidates := []*DataNode{
+		testNode(1, "rack1"),
+		testNode(2, "rack2"),
+	}
+
+	picked := SelectNodesForPartition(candidates, 102, 1, []string{"rack1"})
+	if len(picked) != 1 {
+		t.Fatalf("SelectNodesForPartition returned %v nodes, want 1", len(picked))
+	}
+	if picked[0].RackName != "rack2" {
+		t.Fatalf("SelectNodesForPartition picked rack(%v), want rack2 since rack1 is already used", picked[0].RackName)
+	}
+}
+
+func TestSelectNodesForPartitionRecordsDecision(t *testing.T) {
+	candidates := []*DataNode{testNode(1, "rack1")}
+
+	SelectNodesForPartition(candidates, 103, 1, nil)
+
+	for _, decision := range globalPlacementLog.all() {
+		if decision.PartitionID == 103 {
+			if len(decision.NodeAddrs) != 1 || decision.NodeAddrs[0] != "rack1-node" {
+				t.Fatalf("recorded decision NodeAddrs = %v, want [rack1-node]", decision.NodeAddrs)
+			}
+			return
+		}
+	}
+	t.Fatalf("no placement decision recorded for partition 103")
+}
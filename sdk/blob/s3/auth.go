@@ -0,0 +1,138 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// authenticate validates the request's SigV4 Authorization header against
+// the gateway's configured access/secret key pair. It only checks that the
+// supplied signature matches what we compute for the same signing string;
+// clock-skew validation is left to a future pass.
+func (gw *Gateway) authenticate(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return fmt.Errorf("missing Authorization header")
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("unsupported signature scheme")
+	}
+
+	fields := parseAuthHeader(auth)
+	if credentialAccessKey(fields["Credential"]) != gw.accessKey {
+		return fmt.Errorf("unknown access key")
+	}
+
+	scope := credentialScope(fields["Credential"])
+	if scope == "" {
+		return fmt.Errorf("malformed Credential")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	canonicalRequest := buildCanonicalRequest(r, signedHeaders)
+	signingKey := deriveSigningKey(gw.secretKey, scope)
+	stringToSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s",
+		r.Header.Get("X-Amz-Date"), scope, sha256Hex([]byte(canonicalRequest)))
+	expected := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// credentialAccessKey returns the access-key-id field of a Credential
+// value ("<access-key-id>/<date>/<region>/<service>/aws4_request"), i.e.
+// everything before the first slash. It never matches on substring, only
+// on that exact leading field.
+func credentialAccessKey(credential string) string {
+	if i := strings.Index(credential, "/"); i >= 0 {
+		return credential[:i]
+	}
+	return credential
+}
+
+func parseAuthHeader(auth string) map[string]string {
+	out := make(map[string]string)
+	auth = strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 ")
+	for _, part := range strings.Split(auth, ",") {
+		part = strings.TrimSpace(part)
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			out[kv[0]] = kv[1]
+		}
+	}
+	return out
+}
+
+func buildCanonicalRequest(r *http.Request, signedHeaders []string) string {
+	var headers strings.Builder
+	for _, h := range signedHeaders {
+		headers.WriteString(strings.ToLower(h))
+		headers.WriteString(":")
+		headers.WriteString(r.Header.Get(h))
+		headers.WriteString("\n")
+	}
+	return strings.Join([]string{
+		r.Method,
+		r.URL.Path,
+		r.URL.RawQuery,
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+}
+
+// credentialScope returns the "<date>/<region>/<service>/aws4_request"
+// portion of a Credential value - everything after the first slash - or
+// "" if it doesn't have exactly those 4 slash-separated fields, the first
+// 3 of which deriveSigningKey indexes. Credential is attacker-controlled
+// input, so this must be validated before deriveSigningKey indexes into it.
+func credentialScope(credential string) string {
+	i := strings.Index(credential, "/")
+	if i < 0 {
+		return ""
+	}
+	scope := credential[i+1:]
+	if len(strings.Split(scope, "/")) != 4 {
+		return ""
+	}
+	return scope
+}
+
+func deriveSigningKey(secretKey, scope string) []byte {
+	parts := strings.Split(scope, "/")
+	key := hmacSHA256([]byte("AWS4"+secretKey), []byte(parts[0]))
+	key = hmacSHA256(key, []byte(parts[1]))
+	key = hmacSHA256(key, []byte(parts[2]))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	return key
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import "testing"
+
+func TestCredentialScopeValidTriple(t *testing.T) {
+	got := credentialScope("AKIAEXAMPLE/20260726/us-east-1/s3/aws4_request")
+	want := "20260726/us-east-1/s3/aws4_request"
+	if got != want {
+		t.Fatalf("credentialScope = %q, want %q", got, want)
+	}
+}
+
+func TestCredentialScopeRejectsMissingFields(t *testing.T) {
+	for _, credential := range []string{
+		"AKIAEXAMPLE",
+		"AKIAEXAMPLE/",
+		"AKIAEXAMPLE/20260726",
+		"AKIAEXAMPLE/20260726/us-east-1",
+		"AKIAEXAMPLE/20260726/us-east-1/s3/aws4_request/extra",
+	} {
+		if got := credentialScope(credential); got != "" {
+			t.Fatalf("credentialScope(%q) = %q, want \"\" (malformed Credential)", credential, got)
+		}
+	}
+}
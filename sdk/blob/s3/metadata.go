@@ -0,0 +1,119 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// objectPart is one part of a multipart-uploaded object: the blob key
+// BlobClient.Write returned for that part's bytes, plus its size so
+// GetObject/HeadObject can report a total Content-Length without having
+// to read the part back first.
+type objectPart struct {
+	BlobKey string
+	Size    int64
+}
+
+// objectMeta is what the gateway keeps about a user-visible S3 key. An
+// object written with a single PutObject has BlobKey set and Parts nil;
+// a completed multipart upload has Parts set (in upload order) and
+// BlobKey empty, so GetObject can stream each part's bytes straight
+// through instead of holding the whole object in memory.
+type objectMeta struct {
+	BlobKey     string
+	Parts       []objectPart
+	ContentType string
+	ETag        string
+	Size        int64
+	UserMeta    map[string]string
+	ModTime     time.Time
+}
+
+// metaIndex maps bucket -> object key -> objectMeta. It is the only
+// piece of state this gateway owns; everything else lives in the blob
+// cluster already addressed by BlobClient.
+//
+// This index is in-process memory only and is not persisted anywhere:
+// a gateway restart loses every key->blobKey mapping it ever recorded.
+// The bytes themselves stay in the cluster, but with no surviving
+// record of which blob keys made up which S3 object, they become
+// permanently unreachable and undeletable through this gateway. Giving
+// this index a durable backing store (e.g. writing it through a chubaofs
+// path the way a real filer would) is a bigger change than anything
+// tracked in this series and is left for follow-up work.
+type metaIndex struct {
+	mu      sync.RWMutex
+	objects map[string]*objectMeta
+}
+
+func newMetaIndex() *metaIndex {
+	return &metaIndex{objects: make(map[string]*objectMeta)}
+}
+
+func (idx *metaIndex) put(key string, meta *objectMeta) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.objects[key] = meta
+}
+
+func (idx *metaIndex) get(key string) (*objectMeta, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	meta, ok := idx.objects[key]
+	return meta, ok
+}
+
+func (idx *metaIndex) delete(key string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.objects, key)
+}
+
+// list returns up to maxKeys object keys with the given prefix, starting
+// strictly after startAfter, in lexical order, plus whether more results
+// remain.
+func (idx *metaIndex) list(prefix, startAfter string, maxKeys int) (keys []string, meta []*objectMeta, truncated bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	all := make([]string, 0, len(idx.objects))
+	for k := range idx.objects {
+		if len(prefix) > 0 && !hasPrefix(k, prefix) {
+			continue
+		}
+		if startAfter != "" && k <= startAfter {
+			continue
+		}
+		all = append(all, k)
+	}
+	sort.Strings(all)
+
+	if len(all) > maxKeys {
+		truncated = true
+		all = all[:maxKeys]
+	}
+	keys = all
+	for _, k := range keys {
+		meta = append(meta, idx.objects[k])
+	}
+	return
+}
+
+func hasPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
+}
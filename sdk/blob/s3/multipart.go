@@ -0,0 +1,205 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tiglabs/containerfs/util/log"
+)
+
+// newUploadID returns a random, URL-safe identifier for a multipart upload.
+func newUploadID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// uploadedPart is what UploadPart records for one part: the blob key its
+// bytes were written under, its size, and its raw MD5 digest. Keeping the
+// digest lets CompleteMultipartUpload derive the S3-style multipart ETag
+// without reading any part back.
+type uploadedPart struct {
+	blobKey string
+	size    int64
+	md5     [md5.Size]byte
+}
+
+// multipartUpload tracks the ordered part->uploadedPart mapping for a
+// single UploadId, from CreateMultipartUpload until it is completed or
+// aborted. Each part maps to exactly one BlobClient.Write call, and
+// CompleteMultipartUpload addresses the object by that ordered part list
+// instead of reassembling and re-writing the whole payload.
+type multipartUpload struct {
+	mu          sync.Mutex
+	bucket      string
+	object      string
+	contentType string
+	parts       map[int]uploadedPart // partNumber -> uploaded part
+}
+
+// multipartIndex holds in-flight multipart uploads keyed by UploadId.
+// Like metaIndex, it is in-process memory only: a gateway restart
+// strands any multipart upload that hadn't been completed yet, and the
+// parts already written for it become unreachable orphans in the
+// cluster with no CompleteMultipartUpload ever able to find them again.
+type multipartIndex struct {
+	mu      sync.RWMutex
+	uploads map[string]*multipartUpload
+}
+
+func newMultipartIndex() *multipartIndex {
+	return &multipartIndex{uploads: make(map[string]*multipartUpload)}
+}
+
+type initiateMultipartUploadResultXML struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (gw *Gateway) initiateMultipartUpload(w http.ResponseWriter, r *http.Request, object string) {
+	uploadID := newUploadID()
+	gw.uploads.mu.Lock()
+	gw.uploads.uploads[uploadID] = &multipartUpload{
+		bucket:      gw.bucket,
+		object:      object,
+		contentType: r.Header.Get("Content-Type"),
+		parts:       make(map[int]uploadedPart),
+	}
+	gw.uploads.mu.Unlock()
+
+	writeXML(w, initiateMultipartUploadResultXML{Bucket: gw.bucket, Key: object, UploadID: uploadID})
+}
+
+func (gw *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, object string) {
+	q := r.URL.Query()
+	uploadID := q.Get("uploadId")
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "partNumber must be an integer")
+		return
+	}
+
+	gw.uploads.mu.RLock()
+	upload, ok := gw.uploads.uploads[uploadID]
+	gw.uploads.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	blobKey, err := gw.client.Write(data)
+	if err != nil {
+		log.LogErrorf("s3 UploadPart(%v/%v) upload(%v) part(%v) Write err(%v)",
+			gw.bucket, object, uploadID, partNumber, err)
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	upload.mu.Lock()
+	upload.parts[partNumber] = uploadedPart{blobKey: blobKey, size: int64(len(data)), md5: md5.Sum(data)}
+	upload.mu.Unlock()
+
+	w.Header().Set("ETag", etagOf(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (gw *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, object string) {
+	uploadID := r.URL.Query().Get("uploadId")
+
+	gw.uploads.mu.Lock()
+	upload, ok := gw.uploads.uploads[uploadID]
+	if ok {
+		delete(gw.uploads.uploads, uploadID)
+	}
+	gw.uploads.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", uploadID)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	// The whole point of tracking each part's blob key and size at
+	// UploadPart time is that completion never has to read a part back
+	// or re-write the assembled payload: it just records the ordered
+	// part list as the object's addressing info.
+	parts := make([]objectPart, 0, len(upload.parts))
+	digests := make([]byte, 0, len(upload.parts)*md5.Size)
+	var total int64
+	for i := 1; i <= len(upload.parts); i++ {
+		part, ok := upload.parts[i]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("missing part %d", i))
+			return
+		}
+		parts = append(parts, objectPart{BlobKey: part.blobKey, Size: part.size})
+		digests = append(digests, part.md5[:]...)
+		total += part.size
+	}
+
+	meta := &objectMeta{
+		Parts:       parts,
+		ContentType: upload.contentType,
+		ETag:        multipartETag(digests, len(parts)),
+		Size:        total,
+		ModTime:     time.Now(),
+	}
+	gw.index.put(object, meta)
+
+	writeXML(w, completeMultipartUploadResultXML{Bucket: gw.bucket, Key: object, ETag: meta.ETag})
+}
+
+// multipartETag follows the de facto S3 convention for a multipart
+// object's ETag: the MD5 of the concatenation of each part's raw MD5
+// digest, hex-encoded, suffixed with the part count so clients can tell
+// it apart from a single-part object's plain content MD5.
+func multipartETag(partDigests []byte, partCount int) string {
+	sum := md5.Sum(partDigests)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), partCount)
+}
+
+type completeMultipartUploadResultXML struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (gw *Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, object string) {
+	uploadID := r.URL.Query().Get("uploadId")
+	gw.uploads.mu.Lock()
+	delete(gw.uploads.uploads, uploadID)
+	gw.uploads.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tiglabs/containerfs/util/log"
+)
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (gw *Gateway) putObject(w http.ResponseWriter, r *http.Request, object string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	blobKey, err := gw.client.Write(data)
+	if err != nil {
+		log.LogErrorf("s3 PutObject(%v/%v) Write err(%v)", gw.bucket, object, err)
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	meta := &objectMeta{
+		BlobKey:     blobKey,
+		ContentType: r.Header.Get("Content-Type"),
+		ETag:        etagOf(data),
+		Size:        int64(len(data)),
+		UserMeta:    userMetaFromHeaders(r.Header),
+		ModTime:     time.Now(),
+	}
+	gw.index.put(object, meta)
+
+	w.Header().Set("ETag", meta.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (gw *Gateway) getObject(w http.ResponseWriter, r *http.Request, object string) {
+	meta, ok := gw.index.get(object)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", object)
+		return
+	}
+
+	if len(meta.Parts) > 0 {
+		// Stream part by part so a multipart object is never fully
+		// buffered in memory on its way out, the same reason it was
+		// never reassembled into one blob key at CompleteMultipartUpload.
+		// Headers must go out before the first part, so a mid-stream
+		// read failure can only be logged, not turned into a clean error
+		// response - an unavoidable tradeoff of not buffering the body.
+		writeObjectHeaders(w, meta)
+		for i, part := range meta.Parts {
+			data, err := gw.client.Read(part.BlobKey)
+			if err != nil {
+				log.LogErrorf("s3 GetObject(%v/%v) Read part(%v) err(%v)", gw.bucket, object, i, err)
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				log.LogWarnf("s3 GetObject(%v/%v) write part(%v) to client err(%v)", gw.bucket, object, i, err)
+				return
+			}
+		}
+		return
+	}
+
+	data, err := gw.client.Read(meta.BlobKey)
+	if err != nil {
+		log.LogErrorf("s3 GetObject(%v/%v) Read err(%v)", gw.bucket, object, err)
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	writeObjectHeaders(w, meta)
+	w.Write(data)
+}
+
+func (gw *Gateway) headObject(w http.ResponseWriter, r *http.Request, object string) {
+	meta, ok := gw.index.get(object)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", object)
+		return
+	}
+	writeObjectHeaders(w, meta)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (gw *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, object string) {
+	meta, ok := gw.index.get(object)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if len(meta.Parts) > 0 {
+		for i, part := range meta.Parts {
+			if err := gw.client.Delete(part.BlobKey); err != nil {
+				log.LogErrorf("s3 DeleteObject(%v/%v) Delete part(%v) err(%v)", gw.bucket, object, i, err)
+				writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+				return
+			}
+		}
+	} else if err := gw.client.Delete(meta.BlobKey); err != nil {
+		log.LogErrorf("s3 DeleteObject(%v/%v) Delete err(%v)", gw.bucket, object, err)
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	gw.index.delete(object)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listBucketResultXML and listContentXML mirror ListObjectsV2's XML
+// shape with struct tags so encoding/xml escapes object keys for us
+// instead of hand-interpolating them into a template string.
+type listBucketResultXML struct {
+	XMLName     xml.Name         `xml:"ListBucketResult"`
+	Name        string           `xml:"Name"`
+	Prefix      string           `xml:"Prefix"`
+	KeyCount    int              `xml:"KeyCount"`
+	MaxKeys     int              `xml:"MaxKeys"`
+	IsTruncated bool             `xml:"IsTruncated"`
+	Contents    []listContentXML `xml:"Contents"`
+}
+
+type listContentXML struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	ETag         string `xml:"ETag"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (gw *Gateway) listObjectsV2(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	startAfter := q.Get("start-after")
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxKeys = n
+		}
+	}
+
+	keys, metas, truncated := gw.index.list(prefix, startAfter, maxKeys)
+
+	result := listBucketResultXML{
+		Name:        gw.bucket,
+		Prefix:      prefix,
+		KeyCount:    len(keys),
+		MaxKeys:     maxKeys,
+		IsTruncated: truncated,
+	}
+	for i, key := range keys {
+		result.Contents = append(result.Contents, listContentXML{
+			Key:          key,
+			Size:         metas[i].Size,
+			ETag:         metas[i].ETag,
+			LastModified: metas[i].ModTime.UTC().Format(time.RFC3339),
+		})
+	}
+
+	writeXML(w, result)
+}
+
+func writeObjectHeaders(w http.ResponseWriter, meta *objectMeta) {
+	w.Header().Set("Content-Type", meta.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.Header().Set("ETag", meta.ETag)
+	w.Header().Set("Last-Modified", meta.ModTime.UTC().Format(http.TimeFormat))
+	for k, v := range meta.UserMeta {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+}
+
+func userMetaFromHeaders(header http.Header) map[string]string {
+	out := make(map[string]string)
+	for k, v := range header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+			out[k[len("X-Amz-Meta-"):]] = v[0]
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package s3 exposes an S3-compatible HTTP gateway in front of a
+// blob.BlobClient. It is a stateless translation layer: SigV4 auth and
+// S3 object semantics are handled here, while the actual bytes are
+// stored through the existing blob client. The only state the gateway
+// itself owns is the metadata index mapping user-visible S3 keys to
+// blob keys, following the same split seaweedfs uses between its
+// filer (metadata) and volume servers (bytes).
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+
+	"github.com/tiglabs/containerfs/sdk/data/blob"
+	"github.com/tiglabs/containerfs/util/log"
+)
+
+// Gateway serves the S3 API for a single chubaofs volume.
+type Gateway struct {
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *blob.BlobClient
+	index     *metaIndex
+	uploads   *multipartIndex
+}
+
+// NewGateway builds a Gateway backed by a BlobClient for volname/masters.
+// accessKey/secretKey are used to validate SigV4 requests against bucket.
+func NewGateway(bucket, accessKey, secretKey, volname, masters string) (*Gateway, error) {
+	client, err := blob.NewBlobClient(volname, masters)
+	if err != nil {
+		return nil, err
+	}
+	gw := &Gateway{
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    client,
+		index:     newMetaIndex(),
+		uploads:   newMultipartIndex(),
+	}
+	return gw, nil
+}
+
+// ServeHTTP dispatches an S3 request to the matching handler. Routing is
+// done by method and whether the request carries an UploadId/uploads
+// query parameter, mirroring the handful of verbs this gateway supports.
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := gw.authenticate(r); err != nil {
+		writeError(w, http.StatusForbidden, "AccessDenied", err.Error())
+		return
+	}
+
+	bucket, object := splitPath(r.URL.Path)
+	if bucket != gw.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("unknown bucket %q", bucket))
+		return
+	}
+
+	q := r.URL.Query()
+	_, hasUploads := q["uploads"]
+	switch {
+	case object == "" && r.Method == http.MethodGet && q.Get("list-type") == "2":
+		gw.listObjectsV2(w, r)
+	case r.Method == http.MethodPost && hasUploads:
+		gw.initiateMultipartUpload(w, r, object)
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		gw.uploadPart(w, r, object)
+	case r.Method == http.MethodPost && q.Get("uploadId") != "":
+		gw.completeMultipartUpload(w, r, object)
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		gw.abortMultipartUpload(w, r, object)
+	case r.Method == http.MethodPut:
+		gw.putObject(w, r, object)
+	case r.Method == http.MethodGet:
+		gw.getObject(w, r, object)
+	case r.Method == http.MethodHead:
+		gw.headObject(w, r, object)
+	case r.Method == http.MethodDelete:
+		gw.deleteObject(w, r, object)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// ListenAndServe starts the gateway's HTTP listener on addr.
+func (gw *Gateway) ListenAndServe(addr string) error {
+	log.LogInfof("s3 gateway: serving bucket(%v) on(%v)", gw.bucket, addr)
+	return http.ListenAndServe(addr, gw)
+}
+
+func splitPath(path string) (bucket, object string) {
+	path = trimLeadingSlash(path)
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			return path[:i], path[i+1:]
+		}
+	}
+	return path, ""
+}
+
+func trimLeadingSlash(path string) string {
+	if len(path) > 0 && path[0] == '/' {
+		return path[1:]
+	}
+	return path
+}
+
+// errorXML is the S3 error body shape; encoding/xml escapes Code and
+// Message for us, so a key or error string containing XML metacharacters
+// can't break or inject into the response.
+type errorXML struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	writeXML(w, errorXML{Code: code, Message: message})
+}
+
+// writeXML marshals v as an S3-style XML document and writes it to w,
+// preceded by the standard XML declaration.
+func writeXML(w http.ResponseWriter, v interface{}) {
+	fmt.Fprint(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.LogErrorf("s3: failed to encode XML response: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestCredentialAccessKeyExactField(t *testing.T) {
+	got := credentialAccessKey("AKIAEXAMPLE/20260726/us-east-1/s3/aws4_request")
+	if got != "AKIAEXAMPLE" {
+		t.Fatalf("credentialAccessKey = %q, want %q", got, "AKIAEXAMPLE")
+	}
+}
+
+func TestCredentialAccessKeyDoesNotSubstringMatch(t *testing.T) {
+	// A Credential whose access key merely contains the real key as a
+	// substring must not be accepted.
+	got := credentialAccessKey("notAKIAEXAMPLE/20260726/us-east-1/s3/aws4_request")
+	if got == "AKIAEXAMPLE" {
+		t.Fatalf("credentialAccessKey = %q, must not equal the embedded substring", got)
+	}
+}
+
+func TestMultipartETagMatchesS3Convention(t *testing.T) {
+	part1 := md5.Sum([]byte("hello"))
+	part2 := md5.Sum([]byte("world"))
+	digests := append(append([]byte{}, part1[:]...), part2[:]...)
+
+	got := multipartETag(digests, 2)
+
+	sum := md5.Sum(digests)
+	want := fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), 2)
+	if got != want {
+		t.Fatalf("multipartETag = %q, want %q", got, want)
+	}
+}
@@ -24,11 +24,21 @@ import (
 	"hash/crc32"
 	"net"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 const (
 	MaxRetryCnt = 100
+
+	// DefaultHedgeInterval is the hedging threshold used for a host
+	// hedgedRead has no latency history for yet.
+	DefaultHedgeInterval = time.Millisecond * 200
+
+	// latencyEWMAWeight is how much a new sample moves a host's running
+	// latency estimate; lower is smoother/slower to react.
+	latencyEWMAWeight = 0.2
 )
 
 type BlobClient struct {
@@ -36,11 +46,41 @@ type BlobClient struct {
 	volname string
 	conns   *pool.ConnPool
 	wraper  *wrapper.Wrapper
+
+	// readQuorum is how many hosts must agree on a Read before it
+	// returns. hedgeInterval is the hedging threshold used until a host
+	// has enough latency history of its own. Write has no equivalent
+	// client-side quorum: it writes dp.Hosts[0] only and relies on the
+	// storage layer's own chain replication to fan the data out to the
+	// rest of dp.Hosts, the same as Delete and writeShard do.
+	readQuorum    int
+	hedgeInterval time.Duration
+
+	// latency tracks a per-host read-latency EWMA so hedgedRead's "wait
+	// this long before firing the next host" threshold adapts to each
+	// host's recent behavior instead of using one fixed constant for
+	// every host. This lives on BlobClient rather than pool.ConnPool
+	// since connection pooling and per-host latency tracking are
+	// separate concerns here.
+	latency *hostLatencyTracker
 }
 
+// NewBlobClient builds a BlobClient with the default read quorum (a
+// single reply) and the default hedging interval. Use
+// NewBlobClientWithReadQuorum to override those for a given volume.
 func NewBlobClient(volname, masters string) (*BlobClient, error) {
+	return NewBlobClientWithReadQuorum(volname, masters, 1, DefaultHedgeInterval)
+}
+
+// NewBlobClientWithReadQuorum builds a BlobClient with an explicit
+// ReadQuorum and hedging interval. readQuorum <= 1 means a Read is
+// satisfied by the first host to answer.
+func NewBlobClientWithReadQuorum(volname, masters string, readQuorum int, hedgeInterval time.Duration) (*BlobClient, error) {
 	client := new(BlobClient)
 	client.volname = volname
+	client.readQuorum = readQuorum
+	client.hedgeInterval = hedgeInterval
+	client.latency = newHostLatencyTracker()
 	var err error
 	client.conns = pool.NewConnPool()
 	client.wraper, err = wrapper.NewDataPartitionWrapper(volname, masters)
@@ -50,6 +90,41 @@ func NewBlobClient(volname, masters string) (*BlobClient, error) {
 	return client, nil
 }
 
+// hostLatencyTracker keeps a per-host exponentially-weighted moving
+// average of read latencies, so hedgedRead's wait-before-hedging
+// threshold can adapt per host instead of using one fixed constant.
+type hostLatencyTracker struct {
+	mu     sync.RWMutex
+	ewmaNs map[string]float64
+}
+
+func newHostLatencyTracker() *hostLatencyTracker {
+	return &hostLatencyTracker{ewmaNs: make(map[string]float64)}
+}
+
+func (t *hostLatencyTracker) record(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sample := float64(d.Nanoseconds())
+	if prev, ok := t.ewmaNs[host]; ok {
+		t.ewmaNs[host] = prev*(1-latencyEWMAWeight) + sample*latencyEWMAWeight
+	} else {
+		t.ewmaNs[host] = sample
+	}
+}
+
+// estimate returns host's current latency estimate and whether one has
+// been recorded yet.
+func (t *hostLatencyTracker) estimate(host string) (time.Duration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ns, ok := t.ewmaNs[host]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(ns), true
+}
+
 func (client *BlobClient) checkWriteResponse(request, reply *proto.Packet) (err error) {
 	if reply.Opcode != proto.OpOk {
 		return fmt.Errorf("WriteRequest(%v) reply(%v) replyOp Err msg(%v)",
@@ -90,55 +165,81 @@ func (client *BlobClient) checkReadResponse(request, reply *proto.Packet) (err e
 	return
 }
 
+// writeAck is one host's outcome for a Write attempt.
+type writeAck struct {
+	host                       string
+	partitionID, fileID, objID uint64
+	size                       uint32
+	err                        error
+}
+
+func (client *BlobClient) writeOneHost(host string, request *proto.Packet) writeAck {
+	conn, err := client.conns.Get(host)
+	if err != nil {
+		log.LogWarnf("WriteRequest(%v) Get connect from host(%v) err(%v)", request.GetUniqueLogId(), host, err)
+		return writeAck{host: host, err: err}
+	}
+	if err = request.WriteToConn(conn); err != nil {
+		client.conns.CheckErrorForPutConnect(conn, host, err)
+		log.LogWarnf("WriteRequest(%v) Write to host(%v) err(%v)", request.GetUniqueLogId(), host, err)
+		return writeAck{host: host, err: err}
+	}
+	reply := new(proto.Packet)
+	if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
+		client.conns.Put(conn, true)
+		log.LogWarnf("WriteRequest(%v) Read from host(%v) err(%v)", request.GetUniqueLogId(), host, err)
+		return writeAck{host: host, err: err}
+	}
+	if err = client.checkWriteResponse(request, reply); err != nil {
+		client.conns.Put(conn, true)
+		log.LogWarnf("WriteRequest CheckWriteResponse host(%v) error(%v)", host, err)
+		return writeAck{host: host, err: err}
+	}
+	client.conns.Put(conn, false)
+	partitionID, fileID, objID, size := ParsePacket(reply)
+	return writeAck{host: host, partitionID: partitionID, fileID: fileID, objID: objID, size: size}
+}
+
+// Write sends data to dp.Hosts[0] only, the same single-primary path
+// writeShard uses for EC shards and Delete uses for deletes. The
+// partition's chain replicates the write to the rest of dp.Hosts
+// server-side before the primary acks, so the client neither needs nor
+// is able to write the other hosts itself: they'd each assign their own
+// independent fileID/objID for "the same" write, which Read (and its
+// single object ID per key) has no way to reconcile.
 func (client *BlobClient) Write(data []byte) (key string, err error) {
-	var (
-		dp *wrapper.DataPartition
-	)
-	request := NewBlobWritePacket(dp, data)
 	exclude := make([]uint32, 0)
 	for i := 0; i < MaxRetryCnt; i++ {
-		dp, err = client.wraper.GetWriteDataPartition(exclude)
-		if err != nil {
+		dp, getErr := client.wraper.GetWriteDataPartition(exclude)
+		if getErr != nil {
 			log.LogErrorf("Write: No write data partition")
 			return "", syscall.ENOMEM
 		}
-		var (
-			conn *net.TCPConn
-		)
-		if conn, err = client.conns.Get(dp.Hosts[0]); err != nil {
-			log.LogWarnf("WriteRequest(%v) Get connect from host(%) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err.Error())
-			exclude = append(exclude, dp.PartitionID)
-			continue
-		}
-		if err = request.WriteToConn(conn); err != nil {
-			client.conns.CheckErrorForPutConnect(conn, dp.Hosts[0], err)
-			log.LogWarnf("WriteRequest(%v) Write to (%v) host(%) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err.Error())
-			exclude = append(exclude, dp.PartitionID)
-			continue
-		}
-		reply := new(proto.Packet)
-		if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
-			client.conns.Put(conn, true)
-			log.LogWarnf("WriteRequest(%v) Write (%v) host(%) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err.Error())
-			exclude = append(exclude, dp.PartitionID)
-			continue
-		}
-		if err = client.checkWriteResponse(request, reply); err != nil {
-			client.conns.Put(conn, true)
-			log.LogWarnf("WriteRequest CheckWriteResponse error(%v)", err.Error())
+
+		request := NewBlobWritePacket(dp, data)
+		ack := client.writeOneHost(dp.Hosts[0], request)
+		if ack.err != nil {
+			log.LogWarnf("Write: partition(%v) host(%v) err(%v)", dp.PartitionID, dp.Hosts[0], ack.err)
 			exclude = append(exclude, dp.PartitionID)
 			continue
 		}
-		partitionID, fileID, objID, size := ParsePacket(reply)
-		client.conns.Put(conn, false)
-		key = GenKey(client.cluster, client.volname, partitionID, fileID, objID, size)
-		return key, nil
+
+		return GenKey(client.cluster, client.volname, ack.partitionID, ack.fileID, ack.objID, ack.size), nil
 	}
 
 	return "", syscall.EIO
 }
 
 func (client *BlobClient) Read(key string) (data []byte, err error) {
+	if isECKey(key) {
+		cluster, volname, dataShards, parityShards, locs, totalSize, _, err := parseECKey(key)
+		if err != nil {
+			log.LogErrorf("Read: err(%v)", err)
+			return nil, syscall.EINVAL
+		}
+		return client.readEC(cluster, volname, dataShards, parityShards, locs, totalSize, 0)
+	}
+
 	cluster, volname, partitionID, fileID, objID, size, err := ParseKey(key)
 	if err != nil || strings.Compare(cluster, client.cluster) != 0 || strings.Compare(volname, client.volname) != 0 {
 		log.LogErrorf("Read: err(%v)", err)
@@ -152,36 +253,164 @@ func (client *BlobClient) Read(key string) (data []byte, err error) {
 	}
 
 	request := NewBlobReadPacket(partitionID, fileID, objID, size)
-	for _, target := range dp.Hosts {
-		var (
-			conn *net.TCPConn
-		)
-		if conn, err = client.conns.Get(target); err != nil {
-			err = errors.Annotatef(err, "ReadRequest(%v) Get connect from host(%)-", request.GetUniqueLogId(), target)
-			client.conns.Put(conn, true)
-			continue
+	return client.hedgedRead(dp.Hosts, request)
+}
+
+// readResult is one host's outcome for a hedged read attempt.
+type readResult struct {
+	host string
+	data []byte
+	err  error
+}
+
+func (client *BlobClient) readOneHost(host string, request *proto.Packet) readResult {
+	start := time.Now()
+	var conn *net.TCPConn
+	conn, err := client.conns.Get(host)
+	if err != nil {
+		client.conns.Put(conn, true)
+		return readResult{host: host, err: errors.Annotatef(err, "ReadRequest(%v) Get connect from host(%v)-", request.GetUniqueLogId(), host)}
+	}
+	if err = request.WriteToConn(conn); err != nil {
+		client.conns.CheckErrorForPutConnect(conn, host, err)
+		return readResult{host: host, err: errors.Annotatef(err, "ReadRequest(%v) Write To host(%v)-", request.GetUniqueLogId(), host)}
+	}
+	reply := new(proto.Packet)
+	if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
+		client.conns.Put(conn, true)
+		return readResult{host: host, err: errors.Annotatef(err, "ReadRequest(%v) ReadFrom host(%v) err(%v)", request.GetUniqueLogId(), host, err)}
+	}
+	if err = client.checkReadResponse(request, reply); err != nil {
+		client.conns.Put(conn, true)
+		return readResult{host: host, err: errors.Annotatef(err, "ReadRequest CheckReadResponse host(%v)", host)}
+	}
+	client.conns.Put(conn, false)
+	client.latency.record(host, time.Since(start))
+	return readResult{host: host, data: reply.Data}
+}
+
+// hedgeThresholdFor returns how long hedgedRead should wait for host
+// before firing the next one: host's own EWMA read latency once it has
+// been observed, falling back to the client's hedgeInterval until then.
+func (client *BlobClient) hedgeThresholdFor(host string) time.Duration {
+	if estimate, ok := client.latency.estimate(host); ok {
+		return estimate
+	}
+	return client.hedgeInterval
+}
+
+// readQuorumFor returns how many of n hosts must agree on a read before
+// hedgedRead returns. readQuorum <= 1 (the default) is satisfied by the
+// first host to answer, matching Read's historical "first success wins"
+// behavior; a caller-configured quorum > 1 makes hedgedRead wait for
+// that many matching replies as a consistency check before returning.
+func (client *BlobClient) readQuorumFor(n int) int {
+	quorum := client.readQuorum
+	if quorum <= 1 {
+		quorum = 1
+	}
+	if quorum > n {
+		quorum = n
+	}
+	return quorum
+}
+
+// quorumAgreement reports the data shared by at least quorum of
+// successes, if any such agreement exists yet. Results are grouped by
+// their raw bytes rather than merely counted, so a replica that
+// diverges from the rest can never be mistaken for confirming quorum.
+func quorumAgreement(successes []readResult, quorum int) ([]byte, bool) {
+	counts := make(map[string]int, len(successes))
+	for _, res := range successes {
+		k := string(res.data)
+		counts[k]++
+		if counts[k] >= quorum {
+			return res.data, true
 		}
-		if err = request.WriteToConn(conn); err != nil {
-			client.conns.CheckErrorForPutConnect(conn, target, err)
-			err = errors.Annotatef(err, "ReadRequest(%v) Write To host(%)-", request.GetUniqueLogId(), target)
-			continue
+	}
+	return nil, false
+}
+
+// hedgedRead starts a request against hosts[0] and, if it hasn't
+// answered within that host's adaptive hedging threshold, fires a
+// second request at hosts[1], and so on, so a single slow replica no
+// longer serializes the whole read. Once readQuorum replies carrying
+// identical data have come back it returns that data; on failures, and
+// on successes that don't yet reach quorum, it keeps firing the remaining
+// hosts until either quorum is reached or every host has been tried. If every host answers but no
+// readQuorum of them agree byte-for-byte, that's a replica divergence
+// quorum exists precisely to catch, so the read is still best served by
+// the first reply rather than failed outright - but the gap is real and
+// worth alerting on, not just silently handled.
+func (client *BlobClient) hedgedRead(hosts []string, request *proto.Packet) (data []byte, err error) {
+	quorum := client.readQuorumFor(len(hosts))
+	resultCh := make(chan readResult, len(hosts))
+	fired := 0
+	fire := func() (threshold time.Duration) {
+		if fired >= len(hosts) {
+			return 0
 		}
-		reply := new(proto.Packet)
-		if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
-			client.conns.Put(conn, true)
-			err = errors.Annotatef(err, "ReadRequest(%v) ReadFrom host(%) err(%v)", request.GetUniqueLogId(), target)
-			continue
+		host := hosts[fired]
+		threshold = client.hedgeThresholdFor(host)
+		fired++
+		go func() { resultCh <- client.readOneHost(host, request) }()
+		return threshold
+	}
+
+	threshold := fire()
+	timer := time.NewTimer(threshold)
+	defer timer.Stop()
+
+	var lastErr error
+	var successes []readResult
+	pending := 1
+	for pending > 0 {
+		select {
+		case res := <-resultCh:
+			pending--
+			if res.err == nil {
+				successes = append(successes, res)
+				if agreed, ok := quorumAgreement(successes, quorum); ok {
+					return agreed, nil
+				}
+				if fired < len(hosts) {
+					fire()
+					pending++
+				}
+				continue
+			}
+			lastErr = res.err
+			if fired < len(hosts) {
+				fire()
+				pending++
+			}
+		case <-timer.C:
+			if fired < len(hosts) {
+				nextThreshold := fire()
+				pending++
+				timer.Reset(nextThreshold)
+			}
 		}
-		if err = client.checkReadResponse(request, reply); err != nil {
-			client.conns.Put(conn, true)
-			err = errors.Annotatef(err, "ReadRequest CheckReadResponse", request.GetUniqueLogId(), target)
-			continue
+	}
+
+	if len(successes) > 0 {
+		// Every host that was going to answer has answered and none of
+		// them reached byte-for-byte quorum agreement - either quorum is
+		// set higher than the number of hosts that replied, or replicas
+		// actually disagree. Prefer a partial success over failing the
+		// read outright in either case; log so a real divergence doesn't
+		// go unnoticed.
+		if client.readQuorumFor(len(hosts)) > 1 {
+			log.LogWarnf("ReadRequest(%v) %v hosts answered without reaching quorum agreement, returning best-effort reply from host(%v)",
+				request.GetUniqueLogId(), len(successes), successes[0].host)
 		}
-		client.conns.Put(conn, false)
-		return reply.Data, nil
+		return successes[0].data, nil
 	}
 
-	return nil, syscall.EIO
+	if lastErr == nil {
+		lastErr = syscall.EIO
+	}
+	return nil, lastErr
 }
 
 func (client *BlobClient) Delete(key string) (err error) {
@@ -0,0 +1,91 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package blob
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadQuorumForDefaultsToOne(t *testing.T) {
+	client := &BlobClient{}
+	if got := client.readQuorumFor(3); got != 1 {
+		t.Fatalf("readQuorumFor(3) with no configured quorum = %v, want 1", got)
+	}
+}
+
+func TestReadQuorumForClampedToHostCount(t *testing.T) {
+	client := &BlobClient{readQuorum: 5}
+	if got := client.readQuorumFor(3); got != 3 {
+		t.Fatalf("readQuorumFor(3) with configured quorum 5 = %v, want 3 (clamped)", got)
+	}
+}
+
+func TestHostLatencyTrackerEstimateUnknownHost(t *testing.T) {
+	tracker := newHostLatencyTracker()
+	if _, ok := tracker.estimate("unknown"); ok {
+		t.Fatalf("estimate for a host with no samples should report ok=false")
+	}
+}
+
+func TestHostLatencyTrackerRecordMovesEstimateTowardSample(t *testing.T) {
+	tracker := newHostLatencyTracker()
+	tracker.record("h1", 100*time.Millisecond)
+	first, ok := tracker.estimate("h1")
+	if !ok || first != 100*time.Millisecond {
+		t.Fatalf("estimate after first sample = %v, ok=%v, want 100ms, true", first, ok)
+	}
+
+	tracker.record("h1", 300*time.Millisecond)
+	second, _ := tracker.estimate("h1")
+	if second <= first || second >= 300*time.Millisecond {
+		t.Fatalf("estimate after second sample = %v, want strictly between %v and 300ms", second, first)
+	}
+}
+
+func TestQuorumAgreementNoMatchYet(t *testing.T) {
+	successes := []readResult{{host: "a", data: []byte("v1")}}
+	if _, ok := quorumAgreement(successes, 2); ok {
+		t.Fatalf("quorumAgreement with 1 reply and quorum 2 should not agree yet")
+	}
+}
+
+func TestQuorumAgreementMatchesIdenticalPayloads(t *testing.T) {
+	successes := []readResult{
+		{host: "a", data: []byte("v1")},
+		{host: "b", data: []byte("v1")},
+	}
+	data, ok := quorumAgreement(successes, 2)
+	if !ok || string(data) != "v1" {
+		t.Fatalf("quorumAgreement = %q, %v, want \"v1\", true", data, ok)
+	}
+}
+
+func TestQuorumAgreementDoesNotCountDivergentReplicas(t *testing.T) {
+	successes := []readResult{
+		{host: "a", data: []byte("v1")},
+		{host: "b", data: []byte("v2-stale")},
+	}
+	if _, ok := quorumAgreement(successes, 2); ok {
+		t.Fatalf("quorumAgreement must not report agreement when the two replies disagree")
+	}
+
+	// A third, matching reply should now push "v1" over quorum.
+	successes = append(successes, readResult{host: "c", data: []byte("v1")})
+	data, ok := quorumAgreement(successes, 2)
+	if !ok || string(data) != "v1" {
+		t.Fatalf("quorumAgreement = %q, %v, want \"v1\", true once 2 replicas agree", data, ok)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package blob
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/tiglabs/containerfs/proto"
+	"github.com/tiglabs/containerfs/sdk/data/wrapper"
+	"github.com/tiglabs/containerfs/util/log"
+)
+
+// ecKeyPrefix marks a key produced by WriteEC so Read can tell it apart
+// from a plain replicated key before parsing it.
+const ecKeyPrefix = "ec"
+
+// ecShardLoc is where one shard of an EC-encoded object landed.
+type ecShardLoc struct {
+	PartitionID uint64
+	FileID      uint64
+	ObjID       uint64
+	Size        uint32
+}
+
+// WriteEC erasure-codes data into dataShards+parityShards shards with
+// klauspost/reedsolomon and writes each shard to its own data partition.
+// Rack names aren't part of what the SDK's wrapper.DataPartition exposes
+// to clients - rack-aware replica placement happens once, server-side,
+// when the master creates a partition (see master.SelectNodesForPartition)
+// - so the best this client can do is avoid putting two shards on the
+// same partition or the same host, via GetWriteDataPartition's existing
+// exclude list and a host-address check against shards already placed.
+// Getting real rack diversity for EC shards would need the master to
+// hand back per-partition rack metadata over the wire, which is a
+// separate, bigger change than this one. Unlike Write, which keeps 3x
+// replica overhead, this trades a little extra read-side work for
+// 1.4x-1.5x storage overhead, the same tradeoff seaweedfs makes for its
+// EC volumes - a better fit for cold or large objects.
+func (client *BlobClient) WriteEC(data []byte, dataShards, parityShards int) (key string, err error) {
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return "", fmt.Errorf("WriteEC: new encoder k(%v) m(%v) err(%v)", dataShards, parityShards, err)
+	}
+
+	shards, err := enc.Split(data)
+	if err != nil {
+		return "", fmt.Errorf("WriteEC: split err(%v)", err)
+	}
+	if err = enc.Encode(shards); err != nil {
+		return "", fmt.Errorf("WriteEC: encode err(%v)", err)
+	}
+	shardSize := len(shards[0])
+
+	locs := make([]ecShardLoc, len(shards))
+	usedHosts := make(map[string]bool, len(shards))
+	for i, shard := range shards {
+		dp, pickErr := client.pickShardPartition(usedHosts)
+		if pickErr != nil {
+			return "", fmt.Errorf("WriteEC: no write data partition for shard(%v) err(%v)", i, pickErr)
+		}
+
+		partitionID, fileID, objID, err := client.writeShard(dp, shard)
+		if err != nil {
+			return "", fmt.Errorf("WriteEC: write shard(%v) to partition(%v) err(%v)", i, dp.PartitionID, err)
+		}
+
+		locs[i] = ecShardLoc{PartitionID: partitionID, FileID: fileID, ObjID: objID, Size: uint32(len(shard))}
+		for _, host := range dp.Hosts {
+			usedHosts[host] = true
+		}
+	}
+
+	key = genECKey(client.cluster, client.volname, dataShards, parityShards, locs, uint64(len(data)), uint32(shardSize))
+	return key, nil
+}
+
+// pickShardPartition returns a write partition for the next EC shard,
+// preferring one that doesn't overlap any host already holding an
+// earlier shard of the same object. If every partition the wrapper
+// offers collides with usedHosts (e.g. a small cluster with fewer
+// partitions than shards), it falls back to whatever partition comes
+// back rather than failing the write outright.
+func (client *BlobClient) pickShardPartition(usedHosts map[string]bool) (dp *wrapper.DataPartition, err error) {
+	exclude := make([]uint32, 0)
+	var fallback *wrapper.DataPartition
+	for attempt := 0; attempt < MaxRetryCnt; attempt++ {
+		dp, err = client.wraper.GetWriteDataPartition(exclude)
+		if err != nil {
+			if fallback != nil {
+				return fallback, nil
+			}
+			return nil, err
+		}
+		if fallback == nil {
+			fallback = dp
+		}
+		if !anyHostUsed(dp.Hosts, usedHosts) {
+			return dp, nil
+		}
+		exclude = append(exclude, dp.PartitionID)
+	}
+	return fallback, nil
+}
+
+func anyHostUsed(hosts []string, usedHosts map[string]bool) bool {
+	for _, host := range hosts {
+		if usedHosts[host] {
+			return true
+		}
+	}
+	return false
+}
+
+// readEC reconstructs an EC-encoded object. It fetches shards in
+// parallel, racing across the k+m locations, and stops as soon as k
+// shards have come back; on a shard failure it keeps waiting for the
+// remaining parity shards until either k are collected or all shard
+// fetches have failed.
+func (client *BlobClient) readEC(cluster, volname string, dataShards, parityShards int, locs []ecShardLoc, totalSize uint64, shardSize uint32) (data []byte, err error) {
+	if strings.Compare(cluster, client.cluster) != 0 || strings.Compare(volname, client.volname) != 0 {
+		return nil, fmt.Errorf("readEC: key belongs to cluster(%v) volname(%v)", cluster, volname)
+	}
+
+	type shardResult struct {
+		index int
+		data  []byte
+		err   error
+	}
+
+	resultCh := make(chan shardResult, len(locs))
+	var wg sync.WaitGroup
+	for i, loc := range locs {
+		wg.Add(1)
+		go func(i int, loc ecShardLoc) {
+			defer wg.Done()
+			shardData, shardErr := client.readShard(loc)
+			resultCh <- shardResult{index: i, data: shardData, err: shardErr}
+		}(i, loc)
+	}
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	shards := make([][]byte, len(locs))
+	collected := 0
+	for res := range resultCh {
+		if res.err != nil {
+			log.LogWarnf("readEC: shard(%v) err(%v)", res.index, res.err)
+			continue
+		}
+		shards[res.index] = res.data
+		collected++
+		if collected >= dataShards {
+			break
+		}
+	}
+	if collected < dataShards {
+		return nil, fmt.Errorf("readEC: only collected %v/%v shards", collected, dataShards)
+	}
+
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("readEC: new encoder err(%v)", err)
+	}
+	if err = enc.Reconstruct(shards); err != nil {
+		return nil, fmt.Errorf("readEC: reconstruct err(%v)", err)
+	}
+
+	data = make([]byte, 0, totalSize)
+	for _, shard := range shards[:dataShards] {
+		data = append(data, shard...)
+	}
+	if uint64(len(data)) > totalSize {
+		data = data[:totalSize]
+	}
+	return data, nil
+}
+
+// genECKey encodes cluster, volname, shard layout and per-shard
+// locations into a single opaque string, analogous to GenKey for
+// replicated objects.
+func genECKey(cluster, volname string, dataShards, parityShards int, locs []ecShardLoc, totalSize uint64, shardSize uint32) string {
+	parts := make([]string, 0, len(locs)+6)
+	parts = append(parts, ecKeyPrefix, cluster, volname,
+		strconv.Itoa(dataShards), strconv.Itoa(parityShards))
+	for _, loc := range locs {
+		parts = append(parts, fmt.Sprintf("%v:%v:%v:%v", loc.PartitionID, loc.FileID, loc.ObjID, loc.Size))
+	}
+	parts = append(parts, strconv.FormatUint(totalSize, 10), strconv.FormatUint(uint64(shardSize), 10))
+	return strings.Join(parts, ",")
+}
+
+// isECKey reports whether key was produced by genECKey.
+func isECKey(key string) bool {
+	return strings.HasPrefix(key, ecKeyPrefix+",")
+}
+
+// parseECKey is the inverse of genECKey.
+func parseECKey(key string) (cluster, volname string, dataShards, parityShards int, locs []ecShardLoc, totalSize uint64, shardSize uint32, err error) {
+	fields := strings.Split(key, ",")
+	if len(fields) < 8 || fields[0] != ecKeyPrefix {
+		return "", "", 0, 0, nil, 0, 0, fmt.Errorf("parseECKey: malformed key(%v)", key)
+	}
+	cluster = fields[1]
+	volname = fields[2]
+	if dataShards, err = strconv.Atoi(fields[3]); err != nil {
+		return
+	}
+	if parityShards, err = strconv.Atoi(fields[4]); err != nil {
+		return
+	}
+
+	shardCount := dataShards + parityShards
+	if len(fields) != 5+shardCount+2 {
+		return "", "", 0, 0, nil, 0, 0, fmt.Errorf("parseECKey: shard count mismatch in key(%v)", key)
+	}
+
+	locs = make([]ecShardLoc, shardCount)
+	for i := 0; i < shardCount; i++ {
+		locFields := strings.Split(fields[5+i], ":")
+		if len(locFields) != 4 {
+			return "", "", 0, 0, nil, 0, 0, fmt.Errorf("parseECKey: malformed shard location(%v)", fields[5+i])
+		}
+		partitionID, _ := strconv.ParseUint(locFields[0], 10, 64)
+		fileID, _ := strconv.ParseUint(locFields[1], 10, 64)
+		objID, _ := strconv.ParseUint(locFields[2], 10, 64)
+		size, _ := strconv.ParseUint(locFields[3], 10, 32)
+		locs[i] = ecShardLoc{PartitionID: partitionID, FileID: fileID, ObjID: objID, Size: uint32(size)}
+	}
+
+	totalSize, err = strconv.ParseUint(fields[5+shardCount], 10, 64)
+	if err != nil {
+		return
+	}
+	shard64, err := strconv.ParseUint(fields[5+shardCount+1], 10, 32)
+	shardSize = uint32(shard64)
+	return
+}
+
+// writeShard sends one EC shard to dp.Hosts[0], the same single-primary
+// path Write uses for replicated objects.
+func (client *BlobClient) writeShard(dp *wrapper.DataPartition, shard []byte) (partitionID, fileID, objID uint64, err error) {
+	request := NewBlobWritePacket(dp, shard)
+	conn, err := client.conns.Get(dp.Hosts[0])
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("writeShard(%v) Get connect from host(%v) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err)
+	}
+	if err = request.WriteToConn(conn); err != nil {
+		client.conns.CheckErrorForPutConnect(conn, dp.Hosts[0], err)
+		return 0, 0, 0, fmt.Errorf("writeShard(%v) Write to host(%v) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err)
+	}
+	reply := new(proto.Packet)
+	if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
+		client.conns.Put(conn, true)
+		return 0, 0, 0, fmt.Errorf("writeShard(%v) Read from host(%v) err(%v)", request.GetUniqueLogId(), dp.Hosts[0], err)
+	}
+	if err = client.checkWriteResponse(request, reply); err != nil {
+		client.conns.Put(conn, true)
+		return 0, 0, 0, err
+	}
+	client.conns.Put(conn, false)
+	partitionID, fileID, objID, _ = ParsePacket(reply)
+	return partitionID, fileID, objID, nil
+}
+
+// readShard fetches one EC shard, trying the hosts of its partition one
+// after another in dp.Hosts order until one answers. Unlike Read, it does
+// not hedge: a host is only tried once the previous one has failed.
+func (client *BlobClient) readShard(loc ecShardLoc) (data []byte, err error) {
+	dp, err := client.wraper.GetDataPartition(loc.PartitionID)
+	if dp == nil {
+		return nil, fmt.Errorf("readShard: no partition(%v) err(%v)", loc.PartitionID, err)
+	}
+
+	request := NewBlobReadPacket(loc.PartitionID, loc.FileID, loc.ObjID, loc.Size)
+	for _, target := range dp.Hosts {
+		var conn *net.TCPConn
+		if conn, err = client.conns.Get(target); err != nil {
+			continue
+		}
+		if err = request.WriteToConn(conn); err != nil {
+			client.conns.CheckErrorForPutConnect(conn, target, err)
+			continue
+		}
+		reply := new(proto.Packet)
+		if err = reply.ReadFromConn(conn, proto.ReadDeadlineTime); err != nil {
+			client.conns.Put(conn, true)
+			continue
+		}
+		if err = client.checkReadResponse(request, reply); err != nil {
+			client.conns.Put(conn, true)
+			continue
+		}
+		client.conns.Put(conn, false)
+		return reply.Data, nil
+	}
+
+	if err == nil {
+		err = syscall.EIO
+	}
+	return nil, err
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The Containerfs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package blob
+
+import "testing"
+
+func TestGenAndParseECKeyRoundTrip(t *testing.T) {
+	locs := []ecShardLoc{
+		{PartitionID: 1, FileID: 2, ObjID: 3, Size: 4096},
+		{PartitionID: 5, FileID: 6, ObjID: 7, Size: 4096},
+		{PartitionID: 8, FileID: 9, ObjID: 10, Size: 4096},
+	}
+	key := genECKey("cluster1", "vol1", 2, 1, locs, 8000, 4096)
+
+	if !isECKey(key) {
+		t.Fatalf("isECKey(%v) = false, want true", key)
+	}
+
+	cluster, volname, dataShards, parityShards, gotLocs, totalSize, shardSize, err := parseECKey(key)
+	if err != nil {
+		t.Fatalf("parseECKey(%v) err = %v", key, err)
+	}
+	if cluster != "cluster1" || volname != "vol1" {
+		t.Fatalf("parseECKey cluster/volname = (%v, %v), want (cluster1, vol1)", cluster, volname)
+	}
+	if dataShards != 2 || parityShards != 1 {
+		t.Fatalf("parseECKey shards = (%v, %v), want (2, 1)", dataShards, parityShards)
+	}
+	if totalSize != 8000 || shardSize != 4096 {
+		t.Fatalf("parseECKey size = (%v, %v), want (8000, 4096)", totalSize, shardSize)
+	}
+	if len(gotLocs) != len(locs) {
+		t.Fatalf("parseECKey got %v locs, want %v", len(gotLocs), len(locs))
+	}
+	for i, loc := range locs {
+		if gotLocs[i] != loc {
+			t.Fatalf("parseECKey loc[%v] = %+v, want %+v", i, gotLocs[i], loc)
+		}
+	}
+}
+
+func TestIsECKeyRejectsReplicatedKey(t *testing.T) {
+	if isECKey("cluster1:vol1:1:2:3:4") {
+		t.Fatalf("isECKey should not treat a plain replicated key as an EC key")
+	}
+}
+
+func TestParseECKeyRejectsMalformedKey(t *testing.T) {
+	if _, _, _, _, _, _, _, err := parseECKey("ec,cluster1,vol1,2,1,1:2:3:4"); err == nil {
+		t.Fatalf("parseECKey should reject a key with a shard-count mismatch")
+	}
+}